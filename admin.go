@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/hashicorp/go-tfe"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// adminAddrEnvVar configures the address admin endpoints (/healthz,
+// /readyz, /metrics) are served on. It must be a different port than the
+// one TFC sends run task payloads to, since none of these are meant to be
+// reachable from TFC.
+const adminAddrEnvVar = "TFC_ADMIN_ADDR"
+
+// startAdminServer starts the admin HTTP server in the background and
+// returns it so the caller can Shutdown it during graceful shutdown.
+func startAdminServer(addr string, tfeClient *tfe.Client) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/readyz", handleReadyz(tfeClient))
+	mux.Handle("/metrics", promhttp.Handler())
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("admin server error", "error", err.Error())
+		}
+	}()
+
+	return srv
+}
+
+// handleHealthz reports whether the process is up, without checking any
+// dependencies.
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte("ok"))
+}
+
+// handleReadyz reports whether the service can currently reach go-tfe,
+// since a run task that can't talk to TFC shouldn't be considered ready
+// to receive payloads.
+func handleReadyz(tfeClient *tfe.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, err := tfeClient.Organizations.List(r.Context(), &tfe.OrganizationListOptions{
+			ListOptions: tfe.ListOptions{PageSize: 1},
+		})
+		if err != nil {
+			tfeAPIErrorsTotal.WithLabelValues("Organizations.List").Inc()
+			http.Error(w, "go-tfe unreachable: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hclsimple"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every runtime setting for the run task service. It's built
+// once at startup by LoadConfig, which starts from sane defaults, layers in
+// an optional YAML or HCL config file, and finally applies environment
+// variable overrides on top — the same layering the go-tfe client itself
+// uses for its own Config (see tfe.DefaultConfig).
+//
+// There's no restricted-credential-key field here: that hardcoded list was
+// superseded by PolicyDir, which points at arbitrary *.rego policies
+// (including the embedded default that replicates the old AWS credential
+// check). Configure restricted keys there, not here.
+type Config struct {
+	ListenAddress string `yaml:"listen_address" hcl:"listen_address,optional"`
+	AdminAddress  string `yaml:"admin_address" hcl:"admin_address,optional"`
+	TLSCertFile   string `yaml:"tls_cert_file" hcl:"tls_cert_file,optional"`
+	TLSKeyFile    string `yaml:"tls_key_file" hcl:"tls_key_file,optional"`
+
+	// AllowedUserAgent is the only User-Agent handleRequest accepts
+	// requests from. TFC's run task requests currently identify
+	// themselves with a fixed string, but this is configurable in case
+	// that ever changes without a code rollout.
+	AllowedUserAgent string `yaml:"allowed_user_agent" hcl:"allowed_user_agent,optional"`
+
+	// PolicyDir is scanned for additional *.rego policies, alongside the
+	// built-in default policy. See PolicyEngine.
+	PolicyDir string `yaml:"policy_dir" hcl:"policy_dir,optional"`
+
+	// MessageTemplates holds a Go text/template source per stage, used to
+	// render that stage's task-result message. Templates are executed
+	// against a messageTemplateData value. A stage with no template
+	// configured falls back to the handler's own default message.
+	MessageTemplates map[string]string `yaml:"message_templates" hcl:"message_templates,optional"`
+
+	HMACKey      string `yaml:"hmac_key" hcl:"hmac_key,optional"`
+	HMACWarnOnly bool   `yaml:"hmac_warn_only" hcl:"hmac_warn_only,optional"`
+
+	WorkerCount         int           `yaml:"worker_count" hcl:"worker_count,optional"`
+	QueueDBPath         string        `yaml:"queue_db_path" hcl:"queue_db_path,optional"`
+	ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period" hcl:"shutdown_grace_period,optional"`
+
+	TFE TFEConfig `yaml:"tfe" hcl:"tfe,block"`
+}
+
+// TFEConfig configures the go-tfe client used to talk to HCP Terraform.
+type TFEConfig struct {
+	Address           string `yaml:"address" hcl:"address,optional"`
+	Token             string `yaml:"token" hcl:"token,optional"`
+	RetryServerErrors bool   `yaml:"retry_server_errors" hcl:"retry_server_errors,optional"`
+}
+
+// Environment variables that override whatever the config file set. These
+// match the TFC_/TFE_ prefixes already used throughout the service; TFE_*
+// mirrors the variable names go-tfe itself reads.
+const (
+	configFileFlagName = "config"
+	configFileEnvVar   = "TFC_CONFIG_FILE"
+
+	listenAddressEnvVar    = "TFC_LISTEN_ADDRESS"
+	tlsCertFileEnvVar      = "TFC_TLS_CERT_FILE"
+	tlsKeyFileEnvVar       = "TFC_TLS_KEY_FILE"
+	allowedUserAgentEnvVar = "TFC_ALLOWED_USER_AGENT"
+	tfeAddressEnvVar       = "TFE_ADDRESS"
+	tfeTokenEnvVar         = "TFE_TOKEN"
+	tfeRetryErrorsEnvVar   = "TFE_RETRY_SERVER_ERRORS"
+)
+
+// defaultAllowedUserAgent is the User-Agent HCP Terraform currently sends
+// on every run task request.
+const defaultAllowedUserAgent = "TFC/1.0 (+https://app.terraform.io; TFC)"
+
+func defaultConfig() Config {
+	return Config{
+		ListenAddress:       ":80",
+		AdminAddress:        ":9090",
+		AllowedUserAgent:    defaultAllowedUserAgent,
+		WorkerCount:         defaultWorkerCount,
+		QueueDBPath:         "tfc-run-task.db",
+		ShutdownGracePeriod: defaultShutdownGracePeriod,
+		TFE: TFEConfig{
+			RetryServerErrors: true,
+		},
+	}
+}
+
+// LoadConfig builds a Config. It checks the -config flag (or
+// TFC_CONFIG_FILE, if the flag wasn't given) for a YAML or HCL file to
+// layer onto the defaults, applies environment variable overrides on top
+// of that, and validates the result so the service fails fast with an
+// actionable error rather than misbehaving at runtime.
+func LoadConfig(args []string) (*Config, error) {
+	fs := flag.NewFlagSet("tfc-run-task-demo", flag.ContinueOnError)
+	configFile := fs.String(configFileFlagName, "", "path to a YAML or HCL config file")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	cfg := defaultConfig()
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(configFileEnvVar)
+	}
+	if path != "" {
+		if err := loadConfigFile(&cfg, path); err != nil {
+			return nil, fmt.Errorf("loading config file %q: %w", path, err)
+		}
+	}
+
+	applyEnvOverrides(&cfg)
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+func loadConfigFile(cfg *Config, path string) error {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(data, cfg)
+	case ".hcl":
+		return hclsimple.DecodeFile(path, nil, cfg)
+	default:
+		return fmt.Errorf("unsupported config file extension %q (expected .yaml, .yml, or .hcl)", filepath.Ext(path))
+	}
+}
+
+func applyEnvOverrides(cfg *Config) {
+	if v := os.Getenv(listenAddressEnvVar); v != "" {
+		cfg.ListenAddress = v
+	}
+	if v := os.Getenv(adminAddrEnvVar); v != "" {
+		cfg.AdminAddress = v
+	}
+	if v := os.Getenv(tlsCertFileEnvVar); v != "" {
+		cfg.TLSCertFile = v
+	}
+	if v := os.Getenv(tlsKeyFileEnvVar); v != "" {
+		cfg.TLSKeyFile = v
+	}
+	if v := os.Getenv(allowedUserAgentEnvVar); v != "" {
+		cfg.AllowedUserAgent = v
+	}
+	if v := os.Getenv(policyDirEnvVar); v != "" {
+		cfg.PolicyDir = v
+	}
+	if v := os.Getenv(hmacKeyEnvVar); v != "" {
+		cfg.HMACKey = v
+	}
+	if v := os.Getenv(hmacWarnOnlyEnvVar); v != "" {
+		cfg.HMACWarnOnly = v == "true"
+	}
+	if v := os.Getenv(workerCountEnvVar); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.WorkerCount = n
+		}
+	}
+	if v := os.Getenv(queueDBPathEnvVar); v != "" {
+		cfg.QueueDBPath = v
+	}
+	if v := os.Getenv(shutdownGracePeriodEnvVar); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			cfg.ShutdownGracePeriod = d
+		}
+	}
+	if v := os.Getenv(tfeAddressEnvVar); v != "" {
+		cfg.TFE.Address = v
+	}
+	if v := os.Getenv(tfeTokenEnvVar); v != "" {
+		cfg.TFE.Token = v
+	}
+	if v := os.Getenv(tfeRetryErrorsEnvVar); v != "" {
+		cfg.TFE.RetryServerErrors = v == "true"
+	}
+}
+
+// validate fails fast on settings that would otherwise surface as a
+// confusing error deep into a run, or not at all until something breaks.
+func (c *Config) validate() error {
+	if c.TFE.Token == "" {
+		return fmt.Errorf("tfe.token (or %s) must be set", tfeTokenEnvVar)
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		return fmt.Errorf("tls_cert_file and tls_key_file must both be set, or neither")
+	}
+	if c.WorkerCount <= 0 {
+		return fmt.Errorf("worker_count must be positive, got %d", c.WorkerCount)
+	}
+	return nil
+}
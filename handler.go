@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+
+	"github.com/hashicorp/go-tfe"
+)
+
+// TaskHandler implements the checks run for a single run task Stage. A
+// TaskHandler is registered via RegisterTaskHandler and is looked up by the
+// Stage of each incoming Payload.
+type TaskHandler interface {
+	// Stage returns the run task stage this handler applies to.
+	Stage() Stage
+
+	// Handle evaluates payload and returns the Result to report back to
+	// HCP Terraform via the task-result callback.
+	Handle(ctx context.Context, tfeClient *tfe.Client, payload Payload) (Result, error)
+}
+
+var taskHandlers = make(map[Stage]TaskHandler)
+
+// RegisterTaskHandler registers h for the stage it reports via Stage().
+// Registering a second handler for the same stage replaces the first.
+func RegisterTaskHandler(h TaskHandler) {
+	taskHandlers[h.Stage()] = h
+}
+
+// handlerForStage looks up the TaskHandler registered for stage, if any.
+func handlerForStage(stage Stage) (TaskHandler, bool) {
+	h, ok := taskHandlers[stage]
+	return h, ok
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// logger is the base structured logger for the service. Call-sites that
+// have a run in scope should use runLogger instead, so every log line for
+// that run carries the same correlation fields.
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+// runLogger returns a logger annotated with the correlation fields needed
+// to trace a single run task payload across logs: its run, workspace, task
+// result, and stage.
+func runLogger(payload Payload) *slog.Logger {
+	return logger.With(
+		"run_id", payload.RunID,
+		"workspace_id", payload.WorkspaceID,
+		"task_result_id", payload.TaskResultID,
+		"stage", payload.Stage,
+	)
+}
+
+// fatal logs msg and err at error level and exits the process. It's meant
+// for startup failures the service can't recover from.
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err.Error())
+	os.Exit(1)
+}
@@ -6,40 +6,14 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
-	"strings"
-	"time"
+	"os"
 
 	"github.com/hashicorp/go-tfe"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
 )
 
-type PrePlanPayload struct {
-	PayloadVersion                  int    `json:"payload_version"`
-	AccessToken                     string `json:"access_token"`
-	Stage                           string `json:"stage"`
-	IsSpeculative                   bool   `json:"is_speculative"`
-	TaskResultID                    string `json:"task_result_id"`
-	TaskResultEnforcementLevel      string `json:"task_result_enforcement_level"`
-	TaskResultCallbackURL           string `json:"task_result_callback_url"`
-	RunAppURL                       string `json:"run_app_url"`
-	RunID                           string `json:"run_id"`
-	RunMessage                      string `json:"run_message"`
-	RunCreatedAt                    string `json:"run_created_at"`
-	RunCreatedBy                    string `json:"run_created_by"`
-	WorkspaceID                     string `json:"workspace_id"`
-	WorkspaceName                   string `json:"workspace_name"`
-	WorkspaceAppURL                 string `json:"workspace_app_url"`
-	OrganizationName                string `json:"organization_name"`
-	VCSRepoURL                      string `json:"vcs_repo_url"`
-	VCSBranch                       string `json:"vcs_branch"`
-	VCSPullRequestURL               string `json:"vcs_pull_request_url"`
-	VCSCommitURL                    string `json:"vcs_commit_url"`
-	ConfigurationVersionID          string `json:"configuration_version_id"`
-	ConfigurationVersionDownloadURL string `json:"configuration_version_download_url"`
-	WorkspaceWorkingDirectory       string `json:"workspace_working_directory"`
-}
-
 type Result struct {
 	Data ResultData `json:"data"`
 }
@@ -54,19 +28,28 @@ type ResultAttributes struct {
 	URL     string `json:"url,omitempty"`
 }
 
-// Queue to store the jobs (JSON payloads)
-var jobQueue = make(chan PrePlanPayload, 100)
+// queueDBPathEnvVar and workerCountEnvVar override Config.QueueDBPath and
+// Config.WorkerCount respectively.
+const (
+	queueDBPathEnvVar = "TFC_QUEUE_DB_PATH"
+	workerCountEnvVar = "TFC_WORKER_COUNT"
+)
 
-var restrictedCredentialKeys = map[string]struct{}{
-	"AWS_ACCESS_KEY_ID":      struct{}{},
-	"AWS_SECRET_ACCESS_KEY":  struct{}{},
-	"AWS_SESSION_EXPIRATION": struct{}{},
-	"AWS_SESSION_TOKEN":      struct{}{},
-}
+// queue holds jobs durably until a worker has successfully delivered their
+// result, surviving a process restart or crash.
+var queue Queue
+
+// appConfig is the service's resolved Config, set once in main before
+// anything else starts.
+var appConfig *Config
 
 func handleRequest(w http.ResponseWriter, r *http.Request) {
+	ctx, span := tracer.Start(r.Context(), "handleRequest")
+	defer span.End()
+	r = r.WithContext(ctx)
+
 	// Check if the request method is POST
-	if r.Method != http.MethodPost || r.UserAgent() != "TFC/1.0 (+https://app.terraform.io; TFC)" {
+	if r.Method != http.MethodPost || r.UserAgent() != appConfig.AllowedUserAgent {
 		http.Error(w, "You aren't a TFC Run Task, go away", http.StatusMethodNotAllowed)
 		return
 	}
@@ -77,17 +60,37 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !verifySignature(body, r.Header.Get("X-Tfc-Task-Signature"), appConfig.HMACKey) {
+		if !appConfig.HMACWarnOnly {
+			http.Error(w, "invalid run task signature", http.StatusUnauthorized)
+			return
+		}
+		logger.Warn("invalid run task signature, allowing request because hmac_warn_only is set")
+	}
+
 	// Parse the JSON payload
-	var payload PrePlanPayload
+	var payload Payload
 	err = json.Unmarshal(body, &payload)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
-		log.Println(err.Error())
+		logger.Error("invalid run task payload", "error", err.Error())
 		return
 	}
 
-	// Add the job to the queue
-	jobQueue <- payload
+	log := runLogger(payload)
+
+	span.SetAttributes(
+		attribute.String("tfc.run_id", payload.RunID),
+		attribute.String("tfc.workspace_id", payload.WorkspaceID),
+		attribute.String("tfc.stage", string(payload.Stage)),
+	)
+
+	// Add the job to the durable queue
+	if err := queue.Enqueue(r.Context(), payload); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Error("enqueueing job failed", "error", err.Error())
+		return
+	}
 
 	// Respond with an HTTP 200 OK status
 	w.WriteHeader(http.StatusOK)
@@ -95,30 +98,66 @@ func handleRequest(w http.ResponseWriter, r *http.Request) {
 }
 
 func main() {
-	tfeClient, err := tfe.NewClient(nil) // Use defaults
+	cfg, err := LoadConfig(os.Args[1:])
+	if err != nil {
+		fatal("loading configuration", err)
+	}
+	appConfig = cfg
+
+	shutdownTracing, err := initTracing(context.Background())
 	if err != nil {
-		log.Fatal(err)
+		fatal("initializing tracing", err)
 	}
+	defer shutdownTracing(context.Background())
 
-	// Start the job processor in a separate goroutine
-	go processJobs(tfeClient)
+	tfeClient, err := tfe.NewClient(&tfe.Config{
+		Address:           cfg.TFE.Address,
+		Token:             cfg.TFE.Token,
+		RetryServerErrors: cfg.TFE.RetryServerErrors,
+	})
+	if err != nil {
+		fatal("creating go-tfe client", err)
+	}
+
+	adminServer := startAdminServer(cfg.AdminAddress, tfeClient)
+	defer adminServer.Close()
+	logger.Info("admin endpoints listening", "address", cfg.AdminAddress)
+
+	policyEngine, err := NewPolicyEngine(cfg.PolicyDir)
+	if err != nil {
+		fatal("loading policies", err)
+	}
+	registerPolicyHandlers(policyEngine)
+
+	boltQueue, err := NewBoltQueue(cfg.QueueDBPath)
+	if err != nil {
+		fatal("opening queue database", err)
+	}
+	defer boltQueue.Close()
+	queue = boltQueue
 
 	// Define the HTTP handler function
 	http.HandleFunc("/", handleRequest)
 
-	// Start the server on port 80
-	log.Println("Server listening on port 80...")
-	log.Fatal(http.ListenAndServe(":80", nil))
+	srv := &http.Server{Addr: cfg.ListenAddress}
+
+	logger.Info("server listening", "address", cfg.ListenAddress)
+	serve(srv, queue, cfg, tfeClient)
+	logger.Info("server shut down")
 }
 
-func sendPatchRequest(url string, payload []byte, authToken string) error {
-	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewBuffer(payload))
+func sendPatchRequest(ctx context.Context, url string, payload []byte, authToken string) error {
+	ctx, span := tracer.Start(ctx, "sendPatchRequest")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewBuffer(payload))
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/vnd.api+json")
 	req.Header.Set("Authorization", "Bearer "+authToken)
+	injectTraceContext(ctx, propagation.HeaderCarrier(req.Header))
 
 	client := http.DefaultClient
 	resp, err := client.Do(req)
@@ -138,63 +177,28 @@ func sendPatchRequest(url string, payload []byte, authToken string) error {
 
 	// Process the response as needed
 	if resp.StatusCode != http.StatusOK {
-		log.Println(respBodyStr)
-		return err
+		logger.Warn("callback returned a non-200 status", "status", resp.StatusCode, "body", respBodyStr)
+		return fmt.Errorf("callback returned %d: %s", resp.StatusCode, respBodyStr)
 	}
 
 	return nil
 }
 
-func processJobs(tfeClient *tfe.Client) {
-	for payload := range jobQueue {
-		log.Printf("Processing job for run: %+v\n", payload.RunID)
-
-		workspaceVars, err := tfeClient.Variables.List(context.Background(), payload.WorkspaceID, nil)
-		if err != nil {
-			log.Println(err.Error())
-		}
-
-		var foundKeys []string
-
-		for _, variable := range workspaceVars.Items {
-			if _, ok := restrictedCredentialKeys[variable.Key]; ok {
-				foundKeys = append(foundKeys, variable.Key)
-			}
-		}
-
-		if len(foundKeys) != 0 {
-			log.Println("Looks like someone accidentally set their own AWS creds! Let's steer them in the right direction...")
-			message := fmt.Sprintf(`
-This workspace appears to have AWS credential variables set on it. AWS credentials are managed on your behalf by the Platform Engineering team. These must be removed immediately to ensure compliance: %s. Go to the "Variables" page in the left side nav and remove these variables, then start another run. If you have any questions, feel free to reach out to platform@mycoolcompany.com. Thanks! `, strings.Join(foundKeys, ", "))
-			result := createFailedResult(message)
-			jsonData, err := json.Marshal(result)
-			if err != nil {
-				log.Println(err.Error())
-			}
-
-			err = sendPatchRequest(payload.TaskResultCallbackURL, jsonData, payload.AccessToken)
-			if err != nil {
-				log.Println(err.Error())
-			}
-
-		} else {
-			result := createPassedResult("No erroenous credentials set on this workspace. Good job! --Platform Engineering Team")
-			jsonData, err := json.Marshal(result)
-			if err != nil {
-				log.Println(err.Error())
-			}
-
-			err = sendPatchRequest(payload.TaskResultCallbackURL, jsonData, payload.AccessToken)
-			if err != nil {
-				log.Println(err.Error())
-			}
-		}
-
-		log.Println("Job complete for run: %s", payload.RunID)
+// dispatch runs the TaskHandler registered for payload.Stage, if any. A
+// stage with no registered handler is treated as passing, since there's
+// nothing configured to check.
+func dispatch(ctx context.Context, tfeClient *tfe.Client, payload Payload) (Result, error) {
+	handler, ok := handlerForStage(payload.Stage)
+	if !ok {
+		return createPassedResult(fmt.Sprintf("No checks configured for stage %q.", payload.Stage)), nil
+	}
 
-		// Sleep for some time before checking for the next job
-		time.Sleep(1 * time.Second)
+	result, err := handler.Handle(ctx, tfeClient, payload)
+	if err != nil {
+		return createFailedResult(fmt.Sprintf("run task handler error: %s", err)), err
 	}
+
+	return result, nil
 }
 
 func createPassedResult(message string) Result {
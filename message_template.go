@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// messageTemplateData is what a configured per-stage message template is
+// executed against.
+type messageTemplateData struct {
+	Payload Payload
+	Policy  PolicyResult
+}
+
+// renderMessage renders the template configured for stage in
+// cfg.MessageTemplates, if any. ok is false if no template is configured
+// for stage, in which case the caller should fall back to its own default
+// message.
+func renderMessage(cfg *Config, stage Stage, data messageTemplateData) (rendered string, ok bool, err error) {
+	if cfg == nil {
+		return "", false, nil
+	}
+
+	src, found := cfg.MessageTemplates[string(stage)]
+	if !found {
+		return "", false, nil
+	}
+
+	tmpl, err := template.New(string(stage)).Parse(src)
+	if err != nil {
+		return "", false, fmt.Errorf("parsing message template for stage %q: %w", stage, err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", false, fmt.Errorf("executing message template for stage %q: %w", stage, err)
+	}
+
+	return buf.String(), true, nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	jobsProcessedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tfc_run_task_jobs_processed_total",
+		Help: "Number of run task jobs processed, by stage and outcome.",
+	}, []string{"stage", "status"})
+
+	callbackLatencySeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tfc_run_task_callback_latency_seconds",
+		Help:    "Latency of the task-result callback POST to HCP Terraform.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"stage"})
+
+	tfeAPIErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tfc_run_task_tfe_api_errors_total",
+		Help: "Number of errors returned by go-tfe API calls, by operation.",
+	}, []string{"operation"})
+
+	queueDepth = prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "tfc_run_task_queue_depth",
+		Help: "Number of jobs currently held in the durable queue.",
+	}, func() float64 {
+		if queue == nil {
+			return 0
+		}
+		depth, err := queue.Depth(context.Background())
+		if err != nil {
+			return 0
+		}
+		return float64(depth)
+	})
+)
+
+func init() {
+	prometheus.MustRegister(jobsProcessedTotal, callbackLatencySeconds, tfeAPIErrorsTotal, queueDepth)
+}
@@ -0,0 +1,48 @@
+package main
+
+// Stage identifies which point in a Terraform run a task payload was sent
+// for. See:
+// https://developer.hashicorp.com/terraform/cloud-docs/api-docs/run-tasks/run-tasks-integration#stages
+type Stage string
+
+const (
+	StagePrePlan   Stage = "pre_plan"
+	StagePostPlan  Stage = "post_plan"
+	StagePreApply  Stage = "pre_apply"
+	StagePostApply Stage = "post_apply"
+)
+
+// Payload is the run task request body HCP Terraform sends for every
+// stage. Fields that only apply to post-plan and post-apply stages are
+// tagged omitempty and are left zero-valued for earlier stages.
+type Payload struct {
+	PayloadVersion                  int    `json:"payload_version"`
+	AccessToken                     string `json:"access_token"`
+	Stage                           Stage  `json:"stage"`
+	IsSpeculative                   bool   `json:"is_speculative"`
+	TaskResultID                    string `json:"task_result_id"`
+	TaskResultEnforcementLevel      string `json:"task_result_enforcement_level"`
+	TaskResultCallbackURL           string `json:"task_result_callback_url"`
+	RunAppURL                       string `json:"run_app_url"`
+	RunID                           string `json:"run_id"`
+	RunMessage                      string `json:"run_message"`
+	RunCreatedAt                    string `json:"run_created_at"`
+	RunCreatedBy                    string `json:"run_created_by"`
+	WorkspaceID                     string `json:"workspace_id"`
+	WorkspaceName                   string `json:"workspace_name"`
+	WorkspaceAppURL                 string `json:"workspace_app_url"`
+	OrganizationName                string `json:"organization_name"`
+	VCSRepoURL                      string `json:"vcs_repo_url"`
+	VCSBranch                       string `json:"vcs_branch"`
+	VCSPullRequestURL               string `json:"vcs_pull_request_url"`
+	VCSCommitURL                    string `json:"vcs_commit_url"`
+	ConfigurationVersionID          string `json:"configuration_version_id"`
+	ConfigurationVersionDownloadURL string `json:"configuration_version_download_url"`
+	WorkspaceWorkingDirectory       string `json:"workspace_working_directory"`
+
+	// Present on post_plan and post_apply payloads.
+	PlanJSONAPIURL       string `json:"plan_json_api_url,omitempty"`
+	ResourceAdditions    int    `json:"resource_additions,omitempty"`
+	ResourceChanges      int    `json:"resource_changes,omitempty"`
+	ResourceDestructions int    `json:"resource_destructions,omitempty"`
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+//go:embed policies/default.rego
+var defaultPolicyFS embed.FS
+
+// policyDirEnvVar, when set, is scanned for additional *.rego files to load
+// alongside the built-in default policy. See Config.PolicyDir.
+const policyDirEnvVar = "TFC_POLICY_DIR"
+
+// PolicyResult aggregates the deny and warn messages produced by evaluating
+// every loaded policy against a single run task input document.
+type PolicyResult struct {
+	Denies []string
+	Warns  []string
+}
+
+// Failed reports whether any policy denied the run.
+func (r PolicyResult) Failed() bool {
+	return len(r.Denies) > 0
+}
+
+// PolicyEngine evaluates a fixed set of Rego modules, loaded once at
+// startup, against run task input documents.
+type PolicyEngine struct {
+	modules map[string]string
+}
+
+// NewPolicyEngine loads the built-in default policy plus any *.rego files
+// found in policyDir, if it's non-empty.
+func NewPolicyEngine(policyDir string) (*PolicyEngine, error) {
+	modules := map[string]string{}
+
+	defaultSrc, err := defaultPolicyFS.ReadFile("policies/default.rego")
+	if err != nil {
+		return nil, fmt.Errorf("reading built-in default policy: %w", err)
+	}
+	modules["policies/default.rego"] = string(defaultSrc)
+
+	if dir := policyDir; dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.rego"))
+		if err != nil {
+			return nil, fmt.Errorf("listing policy directory %q: %w", dir, err)
+		}
+
+		for _, path := range paths {
+			src, err := os.ReadFile(path)
+			if err != nil {
+				return nil, fmt.Errorf("reading policy %q: %w", path, err)
+			}
+			modules[path] = string(src)
+		}
+	}
+
+	return &PolicyEngine{modules: modules}, nil
+}
+
+// toJSONDoc round-trips v through JSON into a map[string]interface{}, since
+// rego.Input only accepts JSON-native values and errors on a raw Go struct.
+func toJSONDoc(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// Evaluate runs every loaded policy's deny and warn rules against input and
+// aggregates the results across all of them.
+func (e *PolicyEngine) Evaluate(ctx context.Context, input map[string]interface{}) (PolicyResult, error) {
+	denies, err := e.evalStringSet(ctx, "data.tfcruntask.deny", input)
+	if err != nil {
+		return PolicyResult{}, fmt.Errorf("evaluating deny rules: %w", err)
+	}
+
+	warns, err := e.evalStringSet(ctx, "data.tfcruntask.warn", input)
+	if err != nil {
+		return PolicyResult{}, fmt.Errorf("evaluating warn rules: %w", err)
+	}
+
+	return PolicyResult{Denies: denies, Warns: warns}, nil
+}
+
+func (e *PolicyEngine) evalStringSet(ctx context.Context, query string, input map[string]interface{}) ([]string, error) {
+	opts := []func(*rego.Rego){
+		rego.Query(query),
+		rego.Input(input),
+	}
+	for name, src := range e.modules {
+		opts = append(opts, rego.Module(name, src))
+	}
+
+	rs, err := rego.New(opts...).Eval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return nil, nil
+	}
+
+	vals, ok := rs[0].Expressions[0].Value.([]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	out := make([]string, 0, len(vals))
+	for _, v := range vals {
+		if s, ok := v.(string); ok {
+			out = append(out, s)
+		}
+	}
+
+	return out, nil
+}
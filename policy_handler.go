@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/go-tfe"
+)
+
+// policyHandler evaluates the configured Rego policies for a single stage,
+// fetching whatever context those policies need (workspace variables, and
+// for post-plan runs, the plan JSON) and aggregating deny/warn results into
+// a single task result. It's registered once per stage via
+// registerPolicyHandlers.
+type policyHandler struct {
+	stage  Stage
+	engine *PolicyEngine
+}
+
+// registerPolicyHandlers registers engine to run at every stage.
+func registerPolicyHandlers(engine *PolicyEngine) {
+	for _, stage := range []Stage{StagePrePlan, StagePostPlan, StagePreApply, StagePostApply} {
+		RegisterTaskHandler(policyHandler{stage: stage, engine: engine})
+	}
+}
+
+func (h policyHandler) Stage() Stage { return h.stage }
+
+func (h policyHandler) Handle(ctx context.Context, tfeClient *tfe.Client, payload Payload) (Result, error) {
+	ctx, span := tracer.Start(ctx, "Variables.List")
+	var workspaceVars *tfe.VariableList
+	err := withRetry(ctx, maxAPIAttempts, func() error {
+		var err error
+		workspaceVars, err = tfeClient.Variables.List(ctx, payload.WorkspaceID, nil)
+		return err
+	})
+	span.End()
+	if err != nil {
+		tfeAPIErrorsTotal.WithLabelValues("Variables.List").Inc()
+		return Result{}, err
+	}
+
+	vars := make([]map[string]interface{}, 0, len(workspaceVars.Items))
+	for _, v := range workspaceVars.Items {
+		vars = append(vars, map[string]interface{}{
+			"key":       v.Key,
+			"category":  string(v.Category),
+			"sensitive": v.Sensitive,
+		})
+	}
+
+	payloadDoc, err := toJSONDoc(payload)
+	if err != nil {
+		return Result{}, fmt.Errorf("converting payload for policy evaluation: %w", err)
+	}
+
+	input := map[string]interface{}{
+		"payload":             payloadDoc,
+		"workspace_variables": vars,
+	}
+
+	if h.stage == StagePostPlan && payload.PlanJSONAPIURL != "" {
+		plan, err := fetchPlanJSON(ctx, payload)
+		if err != nil {
+			return Result{}, fmt.Errorf("fetching plan JSON: %w", err)
+		}
+		input["plan"] = plan
+	}
+
+	policyResult, err := h.engine.Evaluate(ctx, input)
+	if err != nil {
+		return Result{}, err
+	}
+
+	message, ok, err := renderMessage(appConfig, h.stage, messageTemplateData{Payload: payload, Policy: policyResult})
+	if err != nil {
+		return Result{}, err
+	}
+	if !ok {
+		message = defaultPolicyMessage(policyResult)
+	}
+
+	if policyResult.Failed() {
+		return createFailedResult(message), nil
+	}
+
+	return createPassedResult(message), nil
+}
+
+// defaultPolicyMessage builds the task-result message used when no message
+// template is configured for the stage.
+func defaultPolicyMessage(policyResult PolicyResult) string {
+	if policyResult.Failed() {
+		return strings.Join(policyResult.Denies, " ")
+	}
+	if len(policyResult.Warns) != 0 {
+		return strings.Join(policyResult.Warns, " ")
+	}
+	return "All policies passed."
+}
+
+// fetchPlanJSON downloads and parses the plan JSON for a post-plan run from
+// the pre-signed URL HCP Terraform includes on the payload.
+func fetchPlanJSON(ctx context.Context, payload Payload) (interface{}, error) {
+	ctx, span := tracer.Start(ctx, "fetchPlanJSON")
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, payload.PlanJSONAPIURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+payload.AccessToken)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var plan interface{}
+	if err := json.Unmarshal(body, &plan); err != nil {
+		return nil, err
+	}
+
+	return plan, nil
+}
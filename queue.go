@@ -0,0 +1,342 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.etcd.io/bbolt"
+)
+
+// Job wraps a Payload with the delivery bookkeeping the Queue needs to
+// retry it without losing track of how many times it's been attempted.
+type Job struct {
+	ID       uint64  `json:"id"`
+	Payload  Payload `json:"payload"`
+	Attempts int     `json:"attempts"`
+}
+
+// Queue is a durable, restart-safe holding area for jobs awaiting
+// processing. Unlike the old in-memory channel, a Queue implementation
+// must not lose enqueued jobs across a process restart.
+type Queue interface {
+	// Enqueue durably stores job for later delivery.
+	Enqueue(ctx context.Context, payload Payload) error
+
+	// Dequeue blocks until a job is available (or ctx is done) and
+	// leases it to the caller, so no other Dequeue/TryDequeue call will
+	// return the same job until it's Ack'd or Retry'd. The caller must
+	// call Ack once the job has been fully processed, or Retry if it
+	// should be redelivered.
+	Dequeue(ctx context.Context) (Job, error)
+
+	// TryDequeue leases and returns the oldest job still queued, without
+	// blocking. ok is false if no job is queued (jobs already leased to
+	// another caller don't count).
+	TryDequeue(ctx context.Context) (job Job, ok bool, err error)
+
+	// Ack permanently removes job from the queue.
+	Ack(ctx context.Context, job Job) error
+
+	// Retry increments job's attempt count and makes it available for
+	// redelivery.
+	Retry(ctx context.Context, job Job) error
+
+	// Close releases any underlying resources (e.g. the database file).
+	Close() error
+
+	// Depth reports how many jobs are currently held in the queue.
+	Depth(ctx context.Context) (int, error)
+}
+
+var (
+	jobsBucket        = []byte("jobs")
+	inflightBucket    = []byte("inflight")
+	sentResultsBucket = []byte("sent_results")
+)
+
+// BoltQueue is a Queue backed by a BoltDB (bbolt) file on disk, so jobs
+// survive a process restart or crash.
+type BoltQueue struct {
+	db     *bbolt.DB
+	notify chan struct{}
+}
+
+// NewBoltQueue opens (creating if necessary) a BoltDB-backed queue at path.
+func NewBoltQueue(path string) (*BoltQueue, error) {
+	db, err := bbolt.Open(path, 0o600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("opening queue database %q: %w", path, err)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(jobsBucket); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(inflightBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(sentResultsBucket)
+		return err
+	}); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating queue buckets: %w", err)
+	}
+
+	q := &BoltQueue{
+		db:     db,
+		notify: make(chan struct{}, 1),
+	}
+
+	if err := q.requeueOrphanedInflight(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("requeuing in-flight jobs from a prior run: %w", err)
+	}
+
+	return q, nil
+}
+
+// requeueOrphanedInflight moves every job left in inflightBucket back into
+// jobsBucket. Since a process only ever leases a job to itself, anything
+// still leased when NewBoltQueue runs must be left over from a previous
+// crash, not a job some other worker is still holding.
+func (q *BoltQueue) requeueOrphanedInflight() error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		inflight := tx.Bucket(inflightBucket)
+		jobs := tx.Bucket(jobsBucket)
+
+		var keys [][]byte
+		if err := inflight.ForEach(func(k, v []byte) error {
+			if err := jobs.Put(k, v); err != nil {
+				return err
+			}
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := inflight.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (q *BoltQueue) Enqueue(ctx context.Context, payload Payload) error {
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(jobsBucket)
+
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		job := Job{ID: id, Payload: payload}
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put(jobKey(id), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	q.wake()
+	return nil
+}
+
+// Dequeue returns the oldest job still in the queue, blocking until one is
+// available or ctx is done. It leases the job to the caller (see claim) so
+// no other Dequeue/TryDequeue call can return the same job until it's Ack'd
+// or Retry'd.
+func (q *BoltQueue) Dequeue(ctx context.Context) (Job, error) {
+	for {
+		job, ok, err := q.claim()
+		if err != nil {
+			return Job{}, err
+		}
+		if ok {
+			return job, nil
+		}
+
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		}
+	}
+}
+
+func (q *BoltQueue) TryDequeue(ctx context.Context) (Job, bool, error) {
+	return q.claim()
+}
+
+// claim atomically moves the oldest job still in jobsBucket into
+// inflightBucket and returns it, in a single transaction, so two
+// concurrent callers can never be handed the same job.
+func (q *BoltQueue) claim() (Job, bool, error) {
+	var job Job
+	var found bool
+
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+		jobs := tx.Bucket(jobsBucket)
+		cursor := jobs.Cursor()
+		k, v := cursor.First()
+		if k == nil {
+			return nil
+		}
+
+		if err := json.Unmarshal(v, &job); err != nil {
+			return err
+		}
+		if err := tx.Bucket(inflightBucket).Put(k, v); err != nil {
+			return err
+		}
+		if err := jobs.Delete(k); err != nil {
+			return err
+		}
+
+		found = true
+		return nil
+	})
+
+	return job, found, err
+}
+
+func (q *BoltQueue) Ack(ctx context.Context, job Job) error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(inflightBucket).Delete(jobKey(job.ID))
+	})
+}
+
+func (q *BoltQueue) Retry(ctx context.Context, job Job) error {
+	job.Attempts++
+
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+		data, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket(jobsBucket).Put(jobKey(job.ID), data); err != nil {
+			return err
+		}
+		return tx.Bucket(inflightBucket).Delete(jobKey(job.ID))
+	})
+	if err != nil {
+		return err
+	}
+
+	q.wake()
+	return nil
+}
+
+// DrainInflight removes and returns every job currently leased to a
+// worker. It's meant for use during shutdown, once a worker has been given
+// up on: the caller gets a chance to report those jobs as failed instead of
+// leaving their runs to hang until TFC's own timeout. Because the worker
+// that leased a job may still be running and could still write its own
+// Ack/Retry/MarkDelivered after this returns, callers should only reach
+// for this once they've already waited out a hard shutdown deadline.
+func (q *BoltQueue) DrainInflight(ctx context.Context) ([]Job, error) {
+	var jobs []Job
+
+	err := q.db.Update(func(tx *bbolt.Tx) error {
+		inflight := tx.Bucket(inflightBucket)
+
+		var keys [][]byte
+		if err := inflight.ForEach(func(k, v []byte) error {
+			var job Job
+			if err := json.Unmarshal(v, &job); err != nil {
+				return err
+			}
+			jobs = append(jobs, job)
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		for _, k := range keys {
+			if err := inflight.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	return jobs, err
+}
+
+func (q *BoltQueue) Close() error {
+	return q.db.Close()
+}
+
+func (q *BoltQueue) Depth(ctx context.Context) (int, error) {
+	var n int
+	err := q.db.View(func(tx *bbolt.Tx) error {
+		n = tx.Bucket(jobsBucket).Stats().KeyN + tx.Bucket(inflightBucket).Stats().KeyN
+		return nil
+	})
+	return n, err
+}
+
+// Delivery states stored in sentResultsBucket, keyed by TaskResultID.
+const (
+	deliveryReserved  = "reserved"
+	deliveryDelivered = "delivered"
+)
+
+// ReserveDelivery atomically claims responsibility for delivering
+// taskResultID. reserved is false if it's already reserved or delivered,
+// in which case the caller must not POST the callback again. This closes
+// the gap a separate check-then-mark would leave open between the two.
+func (q *BoltQueue) ReserveDelivery(taskResultID string) (reserved bool, err error) {
+	err = q.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(sentResultsBucket)
+		if bucket.Get([]byte(taskResultID)) != nil {
+			return nil
+		}
+		reserved = true
+		return bucket.Put([]byte(taskResultID), []byte(deliveryReserved))
+	})
+	return reserved, err
+}
+
+// MarkDelivered records that the task result for taskResultID was
+// successfully delivered.
+func (q *BoltQueue) MarkDelivered(taskResultID string) error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(sentResultsBucket).Put([]byte(taskResultID), []byte(deliveryDelivered))
+	})
+}
+
+// ReleaseDelivery clears a reservation made by ReserveDelivery after a
+// failed delivery attempt, so a later retry of the job can reserve
+// taskResultID again instead of being permanently blocked. It's a no-op if
+// the result was already marked delivered.
+func (q *BoltQueue) ReleaseDelivery(taskResultID string) error {
+	return q.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(sentResultsBucket)
+		if string(bucket.Get([]byte(taskResultID))) == deliveryDelivered {
+			return nil
+		}
+		return bucket.Delete([]byte(taskResultID))
+	})
+}
+
+// wake nudges one blocked Dequeue call, if any, into re-checking the queue.
+func (q *BoltQueue) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+func jobKey(id uint64) []byte {
+	return []byte(fmt.Sprintf("%020d", id))
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// withRetry calls fn up to maxAttempts times, backing off exponentially
+// (starting at 250ms, doubling each time) between attempts. It's used
+// around calls that fail transiently, like outbound HTTP requests to TFC
+// or the go-tfe API.
+func withRetry(ctx context.Context, maxAttempts int, fn func() error) error {
+	const initialBackoff = 250 * time.Millisecond
+
+	var err error
+	backoff := initialBackoff
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+
+		if attempt == maxAttempts {
+			break
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("giving up after %d attempts: %w", maxAttempts, err)
+}
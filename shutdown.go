@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/go-tfe"
+)
+
+// shutdownGracePeriodEnvVar overrides Config.ShutdownGracePeriod: how long
+// to wait for in-flight jobs to finish once a shutdown signal is received
+// before giving up on them.
+const shutdownGracePeriodEnvVar = "TFC_SHUTDOWN_GRACE_PERIOD"
+
+const defaultShutdownGracePeriod = 30 * time.Second
+
+// serve runs srv and the worker pool draining queue until SIGINT or SIGTERM
+// is received. On signal, it stops accepting new HTTP requests but keeps
+// workers pulling and processing the queued backlog for up to
+// cfg.ShutdownGracePeriod. It then waits a second, equal-length grace
+// period for whatever's still in flight at that point to actually finish,
+// since workers process jobs on an unbounded context so the accept
+// deadline alone doesn't stop them mid-job. Only once that second deadline
+// also elapses does it give up on in-flight jobs and fail them outright;
+// everything still queued and not leased to a worker is always failed, so
+// no run is left hanging until TFC's own timeout.
+func serve(srv *http.Server, queue Queue, cfg *Config, tfeClient *tfe.Client) {
+	signalCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// acceptCtx governs how long workers keep pulling new jobs off queue.
+	// It's only cancelled once the shutdown grace period elapses below, so
+	// the queued backlog keeps draining for the full grace period instead
+	// of stopping the instant the signal arrives.
+	acceptCtx, cancelAccept := context.WithCancel(context.Background())
+	defer cancelAccept()
+
+	var wg sync.WaitGroup
+	runWorkers(acceptCtx, &wg, cfg.WorkerCount, queue, tfeClient)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		if cfg.TLSCertFile != "" {
+			serveErr <- srv.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+			return
+		}
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		if err != nil && err != http.ErrServerClosed {
+			fatal("server error", err)
+		}
+		return
+	case <-signalCtx.Done():
+		logger.Info("shutdown signal received, draining queued and in-flight jobs...")
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error(err.Error())
+	}
+
+	// Stop workers from pulling further jobs once the grace period is up,
+	// but let whatever they're already processing run to completion.
+	go func() {
+		<-shutdownCtx.Done()
+		cancelAccept()
+	}()
+
+	workersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(workersDone)
+	}()
+
+	select {
+	case <-workersDone:
+		logger.Info("queue drained before the grace period elapsed")
+		failRemainingJobs(context.Background(), queue)
+		return
+	case <-shutdownCtx.Done():
+		logger.Info("grace period elapsed; no longer accepting new jobs, giving in-flight work one more grace period to finish")
+	}
+
+	hardCtx, hardCancel := context.WithTimeout(context.Background(), cfg.ShutdownGracePeriod)
+	defer hardCancel()
+
+	select {
+	case <-workersDone:
+		logger.Info("in-flight jobs finished within the extra grace period")
+	case <-hardCtx.Done():
+		logger.Warn("hard shutdown deadline elapsed with jobs still in flight, failing them")
+		failInflightJobs(context.Background(), queue)
+	}
+
+	failRemainingJobs(context.Background(), queue)
+}
+
+// failInflightJobs reports a failed task result for every job still leased
+// to a worker past the hard shutdown deadline, on the assumption that
+// worker is stuck and isn't coming back. It's a last resort: that worker
+// may still be running and could still write its own (possibly
+// conflicting) result concurrently, but that's preferable to leaving the
+// run hanging until TFC's own timeout.
+func failInflightJobs(ctx context.Context, queue Queue) {
+	bq, ok := queue.(*BoltQueue)
+	if !ok {
+		return
+	}
+
+	jobs, err := bq.DrainInflight(ctx)
+	if err != nil {
+		logger.Error(err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		payload := job.Payload
+		log := runLogger(payload)
+		message := fmt.Sprintf("The run task service's worker pool did not finish evaluating this run before the hard shutdown deadline (task_result_id=%s). Please re-trigger the run.", payload.TaskResultID)
+
+		jsonData, err := json.Marshal(createFailedResult(message))
+		if err != nil {
+			log.Error(err.Error())
+			continue
+		}
+		if err := sendPatchRequest(ctx, payload.TaskResultCallbackURL, jsonData, payload.AccessToken); err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
+
+// failRemainingJobs reports a failed task result for every job still
+// sitting in queue and not currently leased to a worker, so the
+// corresponding TFC runs don't hang waiting for a task result that will
+// never arrive, then removes them from the queue.
+func failRemainingJobs(ctx context.Context, queue Queue) {
+	for {
+		job, ok, err := queue.TryDequeue(ctx)
+		if err != nil {
+			logger.Error(err.Error())
+			return
+		}
+		if !ok {
+			return
+		}
+
+		payload := job.Payload
+		log := runLogger(payload)
+		message := fmt.Sprintf("The run task service is shutting down and could not finish evaluating this run (task_result_id=%s). Please re-trigger the run.", payload.TaskResultID)
+
+		jsonData, err := json.Marshal(createFailedResult(message))
+		if err != nil {
+			log.Error(err.Error())
+		} else if err := sendPatchRequest(ctx, payload.TaskResultCallbackURL, jsonData, payload.AccessToken); err != nil {
+			log.Error(err.Error())
+		}
+
+		if err := queue.Ack(ctx, job); err != nil {
+			log.Error(err.Error())
+		}
+	}
+}
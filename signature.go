@@ -0,0 +1,45 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+)
+
+// hmacKeyEnvVar is the environment variable holding the shared HMAC key
+// configured on the run task in HCP Terraform, overriding Config.HMACKey.
+// See:
+// https://developer.hashicorp.com/terraform/cloud-docs/api-docs/run-tasks/run-tasks-integration#hmac
+const hmacKeyEnvVar = "TFC_TASK_HMAC_KEY"
+
+// hmacWarnOnlyEnvVar, when set to "true", overrides Config.HMACWarnOnly,
+// causing signature failures to be logged instead of rejected. This is
+// meant for rolling out signature verification on an existing run task
+// without an outage.
+const hmacWarnOnlyEnvVar = "TFC_TASK_HMAC_WARN_ONLY"
+
+// verifySignature reports whether signature, the hex-encoded value of the
+// X-Tfc-Task-Signature header, is a valid HMAC-SHA512 of body under key. If
+// key is empty, verification is skipped and the request is treated as
+// unsigned (useful when HMAC signing hasn't been configured on the run
+// task yet).
+func verifySignature(body []byte, signature, key string) bool {
+	if key == "" {
+		return true
+	}
+
+	if signature == "" {
+		return false
+	}
+
+	want, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha512.New, []byte(key))
+	mac.Write(body)
+	got := mac.Sum(nil)
+
+	return hmac.Equal(got, want)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+const tracerName = "github.com/chrisarcand/tfc-run-task-demo"
+
+var tracer = otel.Tracer(tracerName)
+
+// initTracing configures the global TracerProvider with an OTLP/HTTP
+// exporter (configured via the standard OTEL_EXPORTER_OTLP_* env vars) and
+// returns a shutdown func to flush pending spans on exit.
+func initTracing(ctx context.Context) (func(context.Context) error, error) {
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx, resource.WithAttributes(
+		semconv.ServiceName("tfc-run-task-demo"),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	return tp.Shutdown, nil
+}
+
+// injectTraceContext propagates the current span context onto an outbound
+// request's headers, so the receiving end of a callback POST can join the
+// same trace.
+func injectTraceContext(ctx context.Context, header propagation.TextMapCarrier) {
+	otel.GetTextMapPropagator().Inject(ctx, header)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-tfe"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	defaultWorkerCount = 4
+
+	// maxAPIAttempts bounds retries of a single transient call, like a
+	// go-tfe request or the task-result callback POST.
+	maxAPIAttempts = 3
+
+	// maxJobAttempts bounds how many times a job is redelivered before
+	// it's given up on and dead-lettered.
+	maxJobAttempts = 5
+)
+
+// runWorkers starts n goroutines that each pull jobs from queue and process
+// them against tfeClient until acceptCtx is done, at which point they stop
+// pulling new jobs but finish any job already in hand. wg is done once a
+// worker has stopped pulling and finished its current job, if any, so the
+// caller can wait for a clean drain during shutdown.
+func runWorkers(acceptCtx context.Context, wg *sync.WaitGroup, n int, queue Queue, tfeClient *tfe.Client) {
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go worker(acceptCtx, wg, queue, tfeClient)
+	}
+}
+
+func worker(acceptCtx context.Context, wg *sync.WaitGroup, queue Queue, tfeClient *tfe.Client) {
+	defer wg.Done()
+
+	for {
+		job, err := queue.Dequeue(acceptCtx)
+		if err != nil {
+			if acceptCtx.Err() != nil {
+				return
+			}
+			logger.Error("dequeuing job failed", "error", err.Error())
+			continue
+		}
+
+		// Process the job to completion against an independent
+		// background context, so a shutdown signal (which cancels
+		// acceptCtx to stop pulling new work) doesn't also cut off a
+		// job already in flight.
+		processJob(context.Background(), queue, tfeClient, job)
+	}
+}
+
+// processJob runs the registered handler for job and delivers its result,
+// retrying the job as a whole on failure until maxJobAttempts is reached,
+// at which point it's dead-lettered and dropped.
+func processJob(ctx context.Context, queue Queue, tfeClient *tfe.Client, job Job) {
+	payload := job.Payload
+	log := runLogger(payload)
+
+	ctx, span := tracer.Start(ctx, "processJob")
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("tfc.run_id", payload.RunID),
+		attribute.String("tfc.stage", string(payload.Stage)),
+		attribute.Int("tfc.attempt", job.Attempts+1),
+	)
+
+	log.Info("processing job", "attempt", job.Attempts+1)
+
+	err := deliverResult(ctx, queue, tfeClient, payload)
+	if err == nil {
+		if err := queue.Ack(ctx, job); err != nil {
+			log.Error(err.Error())
+		}
+		jobsProcessedTotal.WithLabelValues(string(payload.Stage), "delivered").Inc()
+		log.Info("job complete")
+		return
+	}
+
+	log.Error("job attempt failed", "error", err.Error())
+
+	if job.Attempts+1 >= maxJobAttempts {
+		jobsProcessedTotal.WithLabelValues(string(payload.Stage), "dead_letter").Inc()
+		log.Error("dead letter: giving up on job", "attempts", job.Attempts+1, "error", err.Error())
+		reportDeadLetter(ctx, payload, err)
+		if err := queue.Ack(ctx, job); err != nil {
+			log.Error(err.Error())
+		}
+		return
+	}
+
+	if err := queue.Retry(ctx, job); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// reportDeadLetter makes a best-effort attempt to tell TFC that payload is
+// being given up on, so its run fails fast instead of hanging until its own
+// timeout waiting for a task result that will never otherwise arrive.
+func reportDeadLetter(ctx context.Context, payload Payload, cause error) {
+	log := runLogger(payload)
+	message := fmt.Sprintf("This run could not be evaluated after %d attempts: %s", maxJobAttempts, cause.Error())
+
+	jsonData, err := json.Marshal(createFailedResult(message))
+	if err != nil {
+		log.Error(err.Error())
+		return
+	}
+
+	if err := sendPatchRequest(ctx, payload.TaskResultCallbackURL, jsonData, payload.AccessToken); err != nil {
+		log.Error(err.Error())
+	}
+}
+
+// deliverResult evaluates payload's task handler and delivers the result.
+// It reserves this TaskResultID for delivery before POSTing, so a
+// redelivered job racing a still in-flight earlier attempt can't cause TFC
+// to see the same run reported twice; the reservation is released again on
+// failure so a later retry can still deliver it.
+func deliverResult(ctx context.Context, queue Queue, tfeClient *tfe.Client, payload Payload) (err error) {
+	bq, dedup := queue.(*BoltQueue)
+	if dedup {
+		var reserved bool
+		reserved, err = bq.ReserveDelivery(payload.TaskResultID)
+		if err != nil {
+			return err
+		}
+		if !reserved {
+			return nil
+		}
+		defer func() {
+			if err != nil {
+				if releaseErr := bq.ReleaseDelivery(payload.TaskResultID); releaseErr != nil {
+					runLogger(payload).Error(releaseErr.Error())
+				}
+			}
+		}()
+	}
+
+	result, err := dispatch(ctx, tfeClient, payload)
+	if err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	start := time.Now()
+	err = withRetry(ctx, maxAPIAttempts, func() error {
+		return sendPatchRequest(ctx, payload.TaskResultCallbackURL, jsonData, payload.AccessToken)
+	})
+	callbackLatencySeconds.WithLabelValues(string(payload.Stage)).Observe(time.Since(start).Seconds())
+	if err != nil {
+		return err
+	}
+
+	if dedup {
+		return bq.MarkDelivered(payload.TaskResultID)
+	}
+
+	return nil
+}